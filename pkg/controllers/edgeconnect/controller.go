@@ -2,7 +2,9 @@ package edgeconnect
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Dynatrace/dynatrace-operator/pkg/api/status"
@@ -15,8 +17,10 @@ import (
 	"github.com/pkg/errors"
 	appsv1 "k8s.io/api/apps/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -28,6 +32,22 @@ import (
 const (
 	errorUpdateInterval   = 1 * time.Minute
 	defaultUpdateInterval = 30 * time.Minute
+
+	// conditionTypeVersionDowngradeBlocked marks that a version reconcile was
+	// refused because it would have downgraded the EdgeConnect image.
+	conditionTypeVersionDowngradeBlocked = "VersionDowngradeBlocked"
+
+	// annotationInsecureRegistry holds a comma-separated list of registry hosts
+	// that are reached with TLS verification skipped, for users running an
+	// internal mirror without a publicly trusted certificate. The request
+	// scheme is left unchanged, so listing a host does not switch it to plain HTTP.
+	annotationInsecureRegistry = "feature.dynatrace.com/insecure-registry"
+
+	// statusUpdateMaxAttempts bounds how many times, the first try included,
+	// updateEdgeConnectStatus attempts a status write that loses to a concurrent update.
+	statusUpdateMaxAttempts       = 5
+	statusUpdateRetryBackoff      = 100 * time.Millisecond
+	statusUpdateRetryJitterFactor = 0.5
 )
 
 // Controller reconciles an EdgeConnect object
@@ -75,9 +95,11 @@ func (controller *Controller) Reconcile(ctx context.Context, request reconcile.R
 		return reconcile.Result{}, nil
 	}
 
+	oldStatus := *edgeConnect.Status.DeepCopy()
+
 	log.Info("updating version info", "name", request.Name, "namespace", request.Namespace)
 
-	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport := newRegistryTransport(edgeConnect)
 	keyChainSecret := edgeConnect.PullSecretWithoutData()
 	registryClient, err := controller.registryClientBuilder(
 		registry.WithContext(ctx),
@@ -91,34 +113,112 @@ func (controller *Controller) Reconcile(ctx context.Context, request reconcile.R
 
 	versionReconciler := version.NewReconciler(controller.apiReader, registryClient, timeprovider.New(), edgeConnect)
 	if err = versionReconciler.Reconcile(ctx); err != nil {
+		var upgradeErr version.InvalidUpgradeError
+		if errors.As(err, &upgradeErr) {
+			log.Info("refusing version downgrade", "name", request.Name, "namespace", request.Namespace, "current", upgradeErr.Current, "target", upgradeErr.Target)
+			edgeConnect.Status.SetPhase(status.Error)
+			meta.SetStatusCondition(&edgeConnect.Status.Conditions, metav1.Condition{
+				Type:    conditionTypeVersionDowngradeBlocked,
+				Status:  metav1.ConditionTrue,
+				Reason:  "InvalidUpgrade",
+				Message: upgradeErr.Error(),
+			})
+
+			if errClient := controller.updateEdgeConnectStatus(ctx, edgeConnect); errClient != nil {
+				return reconcile.Result{RequeueAfter: errorUpdateInterval}, errClient
+			}
+
+			return reconcile.Result{RequeueAfter: defaultUpdateInterval}, nil
+		}
+
 		log.Error(err, "reconciliation of EdgeConnect failed", "name", request.Name, "namespace", request.Namespace)
 		return reconcile.Result{RequeueAfter: errorUpdateInterval}, nil
 	}
 
-	oldStatus := *edgeConnect.Status.DeepCopy()
-
-	err = controller.reconcileEdgeConnect(edgeConnect)
+	reconcileErr := controller.reconcileEdgeConnect(edgeConnect)
 
-	if err != nil {
+	if reconcileErr != nil {
 		edgeConnect.Status.SetPhase(status.Error)
-		log.Error(err, "error reconciling EdgeConnect", "namespace", edgeConnect.Namespace, "name", edgeConnect.Name)
+		log.Error(reconcileErr, "error reconciling EdgeConnect", "namespace", edgeConnect.Namespace, "name", edgeConnect.Name)
 	} else {
 		edgeConnect.Status.SetPhase(status.Running)
 	}
-	err = controller.updateEdgeConnectStatus(ctx, edgeConnect)
 
+	// A single conflict-retrying call, gated on the status (version info
+	// included) actually having changed since this reconcile started, so an
+	// unchanged EdgeConnect isn't rewritten on every 30-minute reconcile.
+	// updateEdgeConnectStatus re-fetches and re-applies this status on a
+	// conflict, so there is no risk of racing a second call here.
 	if isDifferentStatus, err := kubeobjects.IsDifferent(oldStatus, edgeConnect.Status); err != nil {
 		log.Error(errors.WithStack(err), "failed to generate hash for the status section")
 	} else if isDifferentStatus {
-		log.Info("status changed, updating DynaKube")
+		log.Info("status changed, updating EdgeConnect", "name", request.Name, "namespace", request.Namespace)
+
 		if errClient := controller.updateEdgeConnectStatus(ctx, edgeConnect); errClient != nil {
-			return reconcile.Result{RequeueAfter: errorUpdateInterval}, errors.WithMessagef(errClient, "failed to update EdgeConnect after failure, original error: %s", err)
+			return reconcile.Result{RequeueAfter: errorUpdateInterval}, errors.WithMessagef(errClient, "failed to update EdgeConnect status, original error: %v", reconcileErr)
 		}
 	}
 
 	log.Info("reconciling EdgeConnect done", "name", request.Name, "namespace", request.Namespace)
 
-	return reconcile.Result{RequeueAfter: defaultUpdateInterval}, err
+	return reconcile.Result{RequeueAfter: defaultUpdateInterval}, reconcileErr
+}
+
+// newRegistryTransport builds the transport used to talk to every registry an
+// EdgeConnect's images live in. Hosts listed in annotationInsecureRegistry are
+// dialed without TLS verification; every other host keeps the default,
+// verified transport, so naming one internal mirror doesn't weaken TLS for
+// registries (docker.io included) that were never opted out of it.
+func newRegistryTransport(edgeConnect *edgeconnectv1alpha1.EdgeConnect) http.RoundTripper {
+	insecureHosts := insecureRegistryHosts(edgeConnect)
+	if len(insecureHosts) == 0 {
+		return http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	insecureTransport := http.DefaultTransport.(*http.Transport).Clone()
+	insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+
+	return &perHostTransport{
+		secureTransport:   http.DefaultTransport.(*http.Transport).Clone(),
+		insecureTransport: insecureTransport,
+		insecureHosts:     insecureHosts,
+	}
+}
+
+// perHostTransport routes requests to insecureHosts through a transport with
+// TLS verification disabled, and everything else through a normal, verified
+// transport.
+type perHostTransport struct {
+	secureTransport   http.RoundTripper
+	insecureTransport http.RoundTripper
+	insecureHosts     map[string]bool
+}
+
+func (t *perHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.insecureHosts[req.URL.Host] || t.insecureHosts[req.URL.Hostname()] {
+		return t.insecureTransport.RoundTrip(req)
+	}
+
+	return t.secureTransport.RoundTrip(req)
+}
+
+// insecureRegistryHosts parses the comma-separated host list an EdgeConnect
+// opted into unverified-TLS access via annotationInsecureRegistry.
+func insecureRegistryHosts(edgeConnect *edgeconnectv1alpha1.EdgeConnect) map[string]bool {
+	raw := strings.TrimSpace(edgeConnect.Annotations[annotationInsecureRegistry])
+	if raw == "" {
+		return nil
+	}
+
+	hosts := make(map[string]bool)
+
+	for _, host := range strings.Split(raw, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts[host] = true
+		}
+	}
+
+	return hosts
 }
 
 func (controller *Controller) getEdgeConnect(ctx context.Context, name, namespace string) (*edgeconnectv1alpha1.EdgeConnect, error) {
@@ -138,18 +238,46 @@ func (controller *Controller) getEdgeConnect(ctx context.Context, name, namespac
 	return edgeConnect, nil
 }
 
+// updateEdgeConnectStatus persists edgeConnect.Status. A conflicting write
+// (another reconcile updated the object in the meantime) does not give up
+// the status just computed: it re-Gets the EdgeConnect and retries the
+// update against the latest resource version, for up to statusUpdateMaxAttempts
+// attempts in total, so we don't lose the version-status changes to a lost update race.
 func (controller *Controller) updateEdgeConnectStatus(ctx context.Context, edgeConnect *edgeconnectv1alpha1.EdgeConnect) error {
-	edgeConnect.Status.UpdatedTimestamp = *controller.timeProvider.Now()
+	desiredStatus := *edgeConnect.Status.DeepCopy()
 
-	err := controller.client.Status().Update(ctx, edgeConnect)
-	if k8serrors.IsConflict(err) {
-		log.Info("could not update EdgeConnect status due to conflict", "name", edgeConnect.Name)
-		return errors.WithStack(err)
-	} else if err != nil {
-		return errors.WithStack(err)
+	var lastErr error
+
+	for attempt := 0; attempt < statusUpdateMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wait.Jitter(statusUpdateRetryBackoff, statusUpdateRetryJitterFactor))
+
+			latest, err := controller.getEdgeConnect(ctx, edgeConnect.Name, edgeConnect.Namespace)
+			if err != nil {
+				return errors.WithStack(err)
+			} else if latest == nil {
+				return errors.WithStack(lastErr)
+			}
+
+			*edgeConnect = *latest
+		}
+
+		edgeConnect.Status = desiredStatus
+		edgeConnect.Status.UpdatedTimestamp = *controller.timeProvider.Now()
+
+		err := controller.client.Status().Update(ctx, edgeConnect)
+		if err == nil {
+			log.Info("EdgeConnect status updated", "name", edgeConnect.Name, "timestamp", edgeConnect.Status.UpdatedTimestamp)
+			return nil
+		} else if !k8serrors.IsConflict(err) {
+			return errors.WithStack(err)
+		}
+
+		log.Info("could not update EdgeConnect status due to conflict, retrying", "name", edgeConnect.Name, "attempt", attempt+1)
+		lastErr = err
 	}
-	log.Info("EdgeConnect status updated", "name", edgeConnect.Name, "timestamp", edgeConnect.Status.UpdatedTimestamp)
-	return nil
+
+	return errors.WithStack(lastErr)
 }
 
 func (controller *Controller) reconcileEdgeConnect(edgeConnect *edgeconnectv1alpha1.EdgeConnect) error {
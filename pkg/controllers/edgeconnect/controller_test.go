@@ -0,0 +1,73 @@
+package edgeconnect
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	edgeconnectv1alpha1 "github.com/Dynatrace/dynatrace-operator/pkg/api/v1alpha1/edgeconnect"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestInsecureRegistryHosts(t *testing.T) {
+	t.Run("no annotation => no hosts", func(t *testing.T) {
+		edgeConnect := &edgeconnectv1alpha1.EdgeConnect{}
+		assert.Empty(t, insecureRegistryHosts(edgeConnect))
+	})
+	t.Run("parses a comma-separated host list", func(t *testing.T) {
+		edgeConnect := &edgeconnectv1alpha1.EdgeConnect{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					annotationInsecureRegistry: " mirror.internal:5000 , other.internal ",
+				},
+			},
+		}
+		hosts := insecureRegistryHosts(edgeConnect)
+		assert.True(t, hosts["mirror.internal:5000"])
+		assert.True(t, hosts["other.internal"])
+		assert.Len(t, hosts, 2)
+	})
+}
+
+func TestPerHostTransport(t *testing.T) {
+	var securePinged, insecurePinged bool
+
+	transport := &perHostTransport{
+		secureTransport: roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			securePinged = true
+			return &http.Response{}, nil
+		}),
+		insecureTransport: roundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			insecurePinged = true
+			return &http.Response{}, nil
+		}),
+		insecureHosts: map[string]bool{"mirror.internal:5000": true},
+	}
+
+	t.Run("routes a listed host through the insecure transport", func(t *testing.T) {
+		securePinged, insecurePinged = false, false
+
+		_, err := transport.RoundTrip(&http.Request{URL: &url.URL{Host: "mirror.internal:5000"}})
+
+		assert.NoError(t, err)
+		assert.True(t, insecurePinged)
+		assert.False(t, securePinged)
+	})
+
+	t.Run("routes every other host through the secure transport", func(t *testing.T) {
+		securePinged, insecurePinged = false, false
+
+		_, err := transport.RoundTrip(&http.Request{URL: &url.URL{Host: "docker.io"}})
+
+		assert.NoError(t, err)
+		assert.True(t, securePinged)
+		assert.False(t, insecurePinged)
+	})
+}
@@ -0,0 +1,119 @@
+package version
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Dynatrace/dynatrace-operator/pkg/api/status"
+	edgeconnectv1alpha1 "github.com/Dynatrace/dynatrace-operator/pkg/api/v1alpha1/edgeconnect"
+	"github.com/Dynatrace/dynatrace-operator/pkg/oci/registry"
+	"github.com/Dynatrace/dynatrace-operator/pkg/util/timeprovider"
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationFeatureAllowDowngrade lets a user explicitly allow moving an
+// EdgeConnect's image backwards in version, mirroring the DynaKube annotation
+// of the same name.
+const AnnotationFeatureAllowDowngrade = "feature.dynatrace.com/allow-downgrade"
+
+const defaultImageRepository = "docker.io/dynatrace/edgeconnect"
+
+// InvalidUpgradeError is returned when a reconcile would move the EdgeConnect
+// image's version backwards and the downgrade has not been explicitly allowed.
+type InvalidUpgradeError struct {
+	Current string
+	Target  string
+	Reason  string
+}
+
+func (e InvalidUpgradeError) Error() string {
+	return fmt.Sprintf("rejected downgrade from %s to %s: %s", e.Current, e.Target, e.Reason)
+}
+
+// Reconciler refreshes the VersionStatus of the EdgeConnect image.
+type Reconciler struct {
+	apiReader      client.Reader
+	registryClient registry.ImageGetter
+	timeProvider   *timeprovider.Provider
+	edgeConnect    *edgeconnectv1alpha1.EdgeConnect
+}
+
+func NewReconciler(apiReader client.Reader, registryClient registry.ImageGetter, timeProvider *timeprovider.Provider, edgeConnect *edgeconnectv1alpha1.EdgeConnect) *Reconciler {
+	return &Reconciler{
+		apiReader:      apiReader,
+		registryClient: registryClient,
+		timeProvider:   timeProvider,
+		edgeConnect:    edgeConnect,
+	}
+}
+
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	target := &r.edgeConnect.Status.Version
+	previousVersion := target.Version
+
+	imageURI := r.imageURI()
+
+	imageVersion, err := r.registryClient.GetImageVersion(ctx, r.apiReader, r.edgeConnect, imageURI)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	newVersion := imageVersion.Version
+	if newVersion == "" {
+		newVersion = imageVersion.Digest
+	}
+
+	if err := r.checkDowngrade(previousVersion, newVersion); err != nil {
+		return err
+	}
+
+	target.ImageID = imageURI
+	target.Version = newVersion
+	target.Source = status.PublicRegistryVersionSource
+	target.LastProbeTimestamp = r.timeProvider.Now()
+
+	return nil
+}
+
+func (r *Reconciler) imageURI() string {
+	if customImage := r.edgeConnect.Spec.CustomImage(); customImage != "" {
+		return customImage
+	}
+
+	return defaultImageRepository + ":" + r.edgeConnect.Spec.Version()
+}
+
+// checkDowngrade mirrors the guard in the DynaKube version.Reconciler: a
+// downgrade is refused unless either version can't be compared (not
+// semver) or the user opted out via AnnotationFeatureAllowDowngrade.
+func (r *Reconciler) checkDowngrade(currentVersion, targetVersion string) error {
+	if currentVersion == "" || targetVersion == "" || currentVersion == targetVersion {
+		return nil
+	}
+
+	if r.edgeConnect.Annotations[AnnotationFeatureAllowDowngrade] == "true" {
+		return nil
+	}
+
+	current, err := semver.NewVersion(currentVersion)
+	if err != nil {
+		return nil
+	}
+
+	target, err := semver.NewVersion(targetVersion)
+	if err != nil {
+		return nil
+	}
+
+	if target.LessThan(current) {
+		return errors.WithStack(InvalidUpgradeError{
+			Current: currentVersion,
+			Target:  targetVersion,
+			Reason:  fmt.Sprintf("edgeconnect version %s is lower than the currently installed version %s", targetVersion, currentVersion),
+		})
+	}
+
+	return nil
+}
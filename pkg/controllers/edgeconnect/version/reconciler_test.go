@@ -5,13 +5,27 @@ import (
 	"testing"
 
 	"github.com/Dynatrace/dynatrace-operator/pkg/api/scheme/fake"
+	edgeconnectv1alpha1 "github.com/Dynatrace/dynatrace-operator/pkg/api/v1alpha1/edgeconnect"
 	"github.com/Dynatrace/dynatrace-operator/pkg/oci/registry"
 	"github.com/Dynatrace/dynatrace-operator/pkg/oci/registry/mocks"
 	"github.com/Dynatrace/dynatrace-operator/pkg/util/timeprovider"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const fakeDigest = "sha256:3b59ee49247e9ee9ad1025310c5b9cb5197c9f3042e5c5e8c8a1dcfe6af1c1f"
+
+func createBasicEdgeConnect() *edgeconnectv1alpha1.EdgeConnect {
+	return &edgeconnectv1alpha1.EdgeConnect{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-edgeconnect",
+			Namespace: "dynatrace",
+		},
+	}
+}
+
 func TestNewReconcile(t *testing.T) {
 	edgeConnect := createBasicEdgeConnect()
 	fakeRegistryClient := &mocks.MockImageGetter{}
@@ -23,3 +37,37 @@ func TestNewReconcile(t *testing.T) {
 	require.NotNil(t, reconciler)
 	require.NoError(t, reconciler.Reconcile(context.Background()))
 }
+
+func TestReconcile_RefusesDowngrade(t *testing.T) {
+	edgeConnect := createBasicEdgeConnect()
+	edgeConnect.Status.Version.Version = "9.9.9"
+
+	fakeRegistryClient := &mocks.MockImageGetter{}
+	fakeImageVersion := registry.ImageVersion{Version: "1.2.3"}
+	fakeRegistryClient.On("GetImageVersion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fakeImageVersion, nil)
+
+	reconciler := NewReconciler(fake.NewClient(), fakeRegistryClient, timeprovider.New(), edgeConnect)
+
+	err := reconciler.Reconcile(context.Background())
+
+	var upgradeErr InvalidUpgradeError
+	require.ErrorAs(t, err, &upgradeErr)
+	assert.Equal(t, "9.9.9", upgradeErr.Current)
+	assert.Equal(t, "1.2.3", upgradeErr.Target)
+	assert.Equal(t, "9.9.9", edgeConnect.Status.Version.Version)
+}
+
+func TestReconcile_AllowsDowngradeWithAnnotation(t *testing.T) {
+	edgeConnect := createBasicEdgeConnect()
+	edgeConnect.Status.Version.Version = "9.9.9"
+	edgeConnect.Annotations = map[string]string{AnnotationFeatureAllowDowngrade: "true"}
+
+	fakeRegistryClient := &mocks.MockImageGetter{}
+	fakeImageVersion := registry.ImageVersion{Version: "1.2.3"}
+	fakeRegistryClient.On("GetImageVersion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fakeImageVersion, nil)
+
+	reconciler := NewReconciler(fake.NewClient(), fakeRegistryClient, timeprovider.New(), edgeConnect)
+
+	require.NoError(t, reconciler.Reconcile(context.Background()))
+	assert.Equal(t, "1.2.3", edgeConnect.Status.Version.Version)
+}
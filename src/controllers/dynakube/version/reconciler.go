@@ -0,0 +1,440 @@
+package version
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynatracev1beta1 "github.com/Dynatrace/dynatrace-operator/src/api/v1beta1"
+	"github.com/Dynatrace/dynatrace-operator/src/dockerconfig"
+	"github.com/Dynatrace/dynatrace-operator/src/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/src/timeprovider"
+	"github.com/Masterminds/semver/v3"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	baseBackoffInterval = 1 * time.Minute
+	maxBackoffInterval  = 32 * time.Minute
+)
+
+// Updater is implemented once per tracked component (OneAgent, ActiveGate, ...)
+// and tells the Reconciler how to resolve and store that component's VersionStatus.
+type Updater interface {
+	Name() string
+	IsEnabled() bool
+	Target() *dynatracev1beta1.VersionStatus
+	CustomImage() string
+	CustomVersion() string
+	IsAutoUpdateEnabled() bool
+	IsPublicRegistryEnabled() bool
+	LatestImageInfo() (*dtclient.LatestImageInfo, error)
+	UseDefaults(ctx context.Context, dockerCfg *dockerconfig.DockerConfig) error
+
+	// IsInsecureRegistry reports whether the tracked image's registry should be
+	// reached over plain HTTP or with TLS verification skipped, for internal
+	// mirrors running behind a self-signed certificate.
+	IsInsecureRegistry() bool
+
+	// TrustPolicy returns the cosign/notation trust material the resolved
+	// image's signature must satisfy before it is adopted.
+	TrustPolicy() dynatracev1beta1.TrustPolicy
+}
+
+// imageVersion is what hashFunction resolves a tag to: the manifest digest
+// and, if the reference points at a multi-arch OCI image index, the digest
+// for every platform underneath it, keyed by "os/arch" (e.g. "linux/arm64").
+type imageVersion struct {
+	Digest      string
+	ArchDigests map[string]string
+}
+
+type hashFunction func(ctx context.Context, imagePath string, insecure bool, dockerCfg *dockerconfig.DockerConfig) (imageVersion, error)
+
+// archDigestFunction expands an already-resolved digest reference into its
+// per-platform digests, for the case where the DynaKube pins an image by
+// digest (custom image) and that digest happens to be a manifest list. It
+// returns an empty map when the reference points directly at a single
+// platform image.
+type archDigestFunction func(ctx context.Context, imageRef string, insecure bool, dockerCfg *dockerconfig.DockerConfig) (map[string]string, error)
+
+// Reconciler refreshes the VersionStatus of every image an Updater tracks for a single DynaKube.
+type Reconciler struct {
+	dynakube          *dynatracev1beta1.DynaKube
+	timeProvider      *timeprovider.Provider
+	apiReader         client.Reader
+	hashFunc          hashFunction
+	archDigestFunc    archDigestFunction
+	signatureVerifier SignatureVerifier
+
+	clusterArchitectures []string // cached on first use, see clusterPlatforms
+}
+
+// InvalidUpgradeError is returned when a reconcile would move a tracked image's
+// version backwards and the downgrade has not been explicitly allowed.
+type InvalidUpgradeError struct {
+	Current string
+	Target  string
+	Reason  string
+}
+
+func (e InvalidUpgradeError) Error() string {
+	return fmt.Sprintf("rejected downgrade from %s to %s: %s", e.Current, e.Target, e.Reason)
+}
+
+// Reconcile refreshes the VersionStatus of every given Updater (OneAgent,
+// ActiveGate, CodeModules, the extension controller, ...). A failure on one
+// updater does not prevent the others from being reconciled: every error is
+// collected into an aggregate so the caller can still requeue for the
+// subset that failed, while the successful ones keep their refreshed status.
+func (r *Reconciler) Reconcile(ctx context.Context, dockerCfg *dockerconfig.DockerConfig, updaters ...Updater) error {
+	var errs []error
+
+	for _, updater := range updaters {
+		if err := r.runWithBackoff(ctx, updater, dockerCfg); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to update version status for %s", updater.Name()))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+// runWithBackoff skips an updater whose previous attempts have repeatedly
+// failed until enough time has passed, so a broken tag lookup doesn't get
+// hammered every reconcile.
+func (r *Reconciler) runWithBackoff(ctx context.Context, updater Updater, dockerCfg *dockerconfig.DockerConfig) error {
+	target := updater.Target()
+
+	if !r.readyForRetry(target) {
+		return nil
+	}
+
+	if err := r.run(ctx, updater, dockerCfg); err != nil {
+		target.ConsecutiveFailures++
+		target.LastFailureTimestamp = r.timeProvider.Now()
+		return err
+	}
+
+	target.ConsecutiveFailures = 0
+
+	return nil
+}
+
+func (r *Reconciler) readyForRetry(target *dynatracev1beta1.VersionStatus) bool {
+	if target.ConsecutiveFailures == 0 || target.LastFailureTimestamp == nil {
+		return true
+	}
+
+	elapsed := r.timeProvider.Now().Time.Sub(target.LastFailureTimestamp.Time)
+
+	return elapsed >= backoffInterval(target.ConsecutiveFailures)
+}
+
+// backoffInterval doubles the wait time for every consecutive failure, capped
+// at maxBackoffInterval, instead of retrying a failing image every reconcile.
+func backoffInterval(consecutiveFailures int) time.Duration {
+	backoff := baseBackoffInterval
+	for i := 0; i < consecutiveFailures && backoff < maxBackoffInterval; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoffInterval {
+		backoff = maxBackoffInterval
+	}
+
+	return backoff
+}
+
+func (r *Reconciler) run(ctx context.Context, updater Updater, dockerCfg *dockerconfig.DockerConfig) error {
+	if !updater.IsEnabled() {
+		return nil
+	}
+
+	target := updater.Target()
+	previousVersion := target.Version
+
+	customImage := updater.CustomImage()
+
+	var imageURI, customVersion string
+
+	switch {
+	case customImage != "":
+		imageURI = customImage
+	case updater.IsPublicRegistryEnabled():
+		latestImageInfo, err := updater.LatestImageInfo()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		imageURI = latestImageInfo.String()
+	case updater.CustomVersion() != "":
+		customVersion = updater.CustomVersion()
+		if target.ImageRepository == "" {
+			return errors.Errorf("%s: no previously known image repository, cannot apply custom version %q", updater.Name(), customVersion)
+		}
+		imageURI = target.ImageRepository + ":" + customVersion
+	default:
+		if !needsUpdate(updater) {
+			return nil
+		}
+		if err := updater.UseDefaults(ctx, dockerCfg); err != nil {
+			return errors.WithStack(err)
+		}
+		target.Source = determineSource(updater)
+		target.LastProbeTimestamp = r.timeProvider.Now()
+		return nil
+	}
+
+	newVersion, err := extractVersion(imageURI, customVersion)
+	if err != nil {
+		return err
+	}
+
+	if err := checkDowngrade(r.dynakube, updater.Name(), previousVersion, newVersion); err != nil {
+		return err
+	}
+
+	platforms, err := r.clusterPlatforms(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	opts := updateOptions{
+		hashFunc:         r.hashFunc,
+		archDigestFunc:   r.archDigestFunc,
+		insecure:         updater.IsInsecureRegistry(),
+		verifier:         r.signatureVerifier,
+		trustPolicy:      updater.TrustPolicy(),
+		clusterPlatforms: platforms,
+	}
+
+	if err := updateVersionStatus(ctx, target, imageURI, opts, dockerCfg); err != nil {
+		return err
+	}
+
+	target.Source = determineSource(updater)
+	target.Version = newVersion
+	target.LastProbeTimestamp = r.timeProvider.Now()
+
+	return nil
+}
+
+func needsUpdate(updater Updater) bool {
+	if updater.IsAutoUpdateEnabled() {
+		return true
+	}
+
+	target := updater.Target()
+
+	return target.Source == "" || target.Source != determineSource(updater)
+}
+
+func determineSource(updater Updater) dynatracev1beta1.VersionStatusSource {
+	switch {
+	case updater.CustomImage() != "":
+		return dynatracev1beta1.CustomImageVersionSource
+	case updater.IsPublicRegistryEnabled():
+		return dynatracev1beta1.PublicRegistryVersionSource
+	case updater.CustomVersion() != "":
+		return dynatracev1beta1.CustomVersionVersionSource
+	default:
+		return dynatracev1beta1.TenantRegistryVersionSource
+	}
+}
+
+// extractVersion determines the version a status update would move to, without
+// mutating anything, so callers can run checkDowngrade before committing the change.
+func extractVersion(imageURI, customVersion string) (string, error) {
+	if customVersion != "" {
+		return customVersion, nil
+	}
+
+	ref, err := reference.Parse(imageURI)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if tagged, ok := ref.(reference.NamedTagged); ok {
+		return tagged.Tag(), nil
+	}
+
+	if canonical, ok := ref.(reference.Canonical); ok {
+		return canonical.Digest().String(), nil
+	}
+
+	return "", errors.Errorf("image reference %s has neither a tag nor a digest", imageURI)
+}
+
+// checkDowngrade refuses a version update that would move a component backwards.
+// Non-semver versions (custom images pinned by digest, for example) can't be
+// compared and are treated as unknown, so the check is skipped for them.
+func checkDowngrade(dynakube *dynatracev1beta1.DynaKube, componentName, currentVersion, targetVersion string) error {
+	if currentVersion == "" || targetVersion == "" || currentVersion == targetVersion {
+		return nil
+	}
+
+	if allowsDowngrade(dynakube) {
+		return nil
+	}
+
+	current, err := semver.NewVersion(currentVersion)
+	if err != nil {
+		return nil
+	}
+
+	target, err := semver.NewVersion(targetVersion)
+	if err != nil {
+		return nil
+	}
+
+	if target.LessThan(current) {
+		return errors.WithStack(InvalidUpgradeError{
+			Current: currentVersion,
+			Target:  targetVersion,
+			Reason:  fmt.Sprintf("%s version %s is lower than the currently installed version %s", componentName, targetVersion, currentVersion),
+		})
+	}
+
+	return nil
+}
+
+func allowsDowngrade(dynakube *dynatracev1beta1.DynaKube) bool {
+	if dynakube == nil {
+		return false
+	}
+
+	return dynakube.Annotations[dynatracev1beta1.AnnotationFeatureAllowDowngrade] == "true"
+}
+
+// updateOptions bundles the dependencies updateVersionStatus needs to resolve
+// and validate an image beyond the bare tag->digest lookup.
+type updateOptions struct {
+	hashFunc         hashFunction
+	archDigestFunc   archDigestFunction
+	insecure         bool
+	verifier         SignatureVerifier
+	trustPolicy      dynatracev1beta1.TrustPolicy
+	clusterPlatforms []string
+}
+
+// updateVersionStatus resolves imageURI to a digest and, once a
+// signatureVerifier has approved it, writes the result to target. Nothing is
+// written if resolution or verification fails, so a failed signature check
+// never updates LastProbeTimestamp and the operator retries on the next reconcile.
+//
+// When the resolved reference is a multi-arch manifest list, the per-platform
+// digests are stored in target.ArchDigests and ImageHash is set to whichever
+// of them matches a platform actually present in the cluster, so injected
+// init containers get a digest the node can actually run.
+func updateVersionStatus(ctx context.Context, target *dynatracev1beta1.VersionStatus, imageURI string, opts updateOptions, dockerCfg *dockerconfig.DockerConfig) error {
+	ref, err := reference.Parse(imageURI)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var repo, tag, digest string
+
+	var archDigests map[string]string
+
+	if canonical, ok := ref.(reference.Canonical); ok {
+		repo = canonical.Name()
+		digest = canonical.Digest().String()
+		tag = digest
+
+		if opts.archDigestFunc != nil {
+			archDigests, err = opts.archDigestFunc(ctx, imageURI, opts.insecure, dockerCfg)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	} else if tagged, ok := ref.(reference.NamedTagged); ok {
+		resolved, err := opts.hashFunc(ctx, imageURI, opts.insecure, dockerCfg)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		repo = tagged.Name()
+		tag = tagged.Tag()
+		digest = resolved.Digest
+		archDigests = resolved.ArchDigests
+	} else {
+		return errors.Errorf("image reference %s has neither a tag nor a digest", imageURI)
+	}
+
+	if opts.verifier != nil {
+		if err := opts.verifier.Verify(ctx, repo, digest, opts.trustPolicy); err != nil {
+			return err
+		}
+	}
+
+	if len(archDigests) > 0 {
+		if selected, ok := selectDigestForCluster(archDigests, opts.clusterPlatforms); ok {
+			digest = selected
+		}
+	}
+
+	target.ImageRepository = repo
+	target.ImageTag = tag
+	target.ImageHash = digest
+	target.ArchDigests = archDigests
+
+	return nil
+}
+
+// selectDigestForCluster picks the manifest-list entry matching one of the
+// platforms actually present in the cluster. If none of the cluster's
+// platforms are known (the Reconciler has no apiReader, or no node carries
+// os/arch labels) or none of them appear in archDigests, ok is false and the
+// index digest itself is kept as-is by the caller.
+func selectDigestForCluster(archDigests map[string]string, clusterPlatforms []string) (digest string, ok bool) {
+	for _, platform := range clusterPlatforms {
+		if digest, ok := archDigests[platform]; ok {
+			return digest, true
+		}
+	}
+
+	return "", false
+}
+
+// clusterPlatforms returns the "os/arch" platforms ("linux/amd64", ...)
+// actually present in the cluster's nodes, querying the node list once and
+// caching it on the Reconciler for the rest of its lifetime.
+func (r *Reconciler) clusterPlatforms(ctx context.Context) ([]string, error) {
+	if r.clusterArchitectures != nil {
+		return r.clusterArchitectures, nil
+	}
+
+	if r.apiReader == nil {
+		return nil, nil
+	}
+
+	var nodes corev1.NodeList
+	if err := r.apiReader.List(ctx, &nodes); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	seen := map[string]bool{}
+	platforms := make([]string, 0, len(nodes.Items))
+
+	for _, node := range nodes.Items {
+		os := node.Labels[corev1.LabelOSStable]
+		arch := node.Labels[corev1.LabelArchStable]
+
+		if os == "" || arch == "" {
+			continue
+		}
+
+		platform := os + "/" + arch
+		if !seen[platform] {
+			seen[platform] = true
+			platforms = append(platforms, platform)
+		}
+	}
+
+	r.clusterArchitectures = platforms
+
+	return platforms, nil
+}
@@ -0,0 +1,105 @@
+package version
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+
+	dynatracev1beta1 "github.com/Dynatrace/dynatrace-operator/src/api/v1beta1"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	rekor "github.com/sigstore/rekor/pkg/client"
+	sigs "github.com/sigstore/sigstore/pkg/signature"
+)
+
+// SignatureVerifier checks that a resolved image digest carries a signature
+// trusted under the DynaKube/EdgeConnect's spec.trustedSigners policy. It is
+// invoked after the digest is resolved but before it is written to VersionStatus.
+type SignatureVerifier interface {
+	Verify(ctx context.Context, imageRepo, digest string, trustPolicy dynatracev1beta1.TrustPolicy) error
+}
+
+// UntrustedImageError is returned when a resolved image's signature could not
+// be verified against the configured trust policy.
+type UntrustedImageError struct {
+	Image  string
+	Reason string
+}
+
+func (e UntrustedImageError) Error() string {
+	return fmt.Sprintf("rejected untrusted image %s: %s", e.Image, e.Reason)
+}
+
+// CosignVerifier verifies cosign signatures using either the public keys or
+// the keyless (Rekor + certificate identity) references from a TrustPolicy.
+type CosignVerifier struct{}
+
+func (CosignVerifier) Verify(ctx context.Context, imageRepo, digest string, trustPolicy dynatracev1beta1.TrustPolicy) error {
+	if trustPolicy.IsEmpty() {
+		return nil
+	}
+
+	ref, err := name.ParseReference(imageRepo + "@" + digest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	baseOpts := cosign.CheckOpts{
+		IgnoreTlog: trustPolicy.RekorURL == "",
+	}
+
+	if trustPolicy.CertificateIdentity != "" {
+		baseOpts.Identities = []cosign.Identity{{Subject: trustPolicy.CertificateIdentity}}
+	}
+
+	if trustPolicy.RekorURL != "" {
+		rekorClient, err := rekor.GetRekorClient(trustPolicy.RekorURL)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		baseOpts.RekorClient = rekorClient
+
+		rekorPubKeys, err := cosign.GetRekorPubs(ctx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		baseOpts.RekorPubKeys = rekorPubKeys
+	}
+
+	if len(trustPolicy.PublicKeys) == 0 {
+		if _, _, err := cosign.VerifyImageSignatures(ctx, ref, &baseOpts); err != nil {
+			return errors.WithStack(UntrustedImageError{Image: ref.Name(), Reason: err.Error()})
+		}
+
+		return nil
+	}
+
+	// The policy may trust more than one signer key (e.g. during key
+	// rotation); the image is trusted if its signature matches any one of them.
+	var lastErr error
+
+	for _, rawKey := range trustPolicy.PublicKeys {
+		verifier, err := sigs.LoadPublicKeyRaw([]byte(rawKey), crypto.SHA256)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		opts := baseOpts
+		opts.SigVerifier = verifier
+
+		if _, _, err := cosign.VerifyImageSignatures(ctx, ref, &opts); err == nil {
+			return nil
+		} else {
+			lastErr = err
+		}
+	}
+
+	return errors.WithStack(UntrustedImageError{
+		Image:  ref.Name(),
+		Reason: fmt.Sprintf("signature did not match any of the %d trusted public key(s): %s", len(trustPolicy.PublicKeys), lastErr),
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	dynatracev1beta1 "github.com/Dynatrace/dynatrace-operator/src/api/v1beta1"
 	"github.com/Dynatrace/dynatrace-operator/src/dockerconfig"
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type mockUpdater struct {
@@ -55,6 +57,23 @@ func (m *mockUpdater) UseDefaults(_ context.Context, _ *dockerconfig.DockerConfi
 	args := m.Called()
 	return args.Error(0)
 }
+func (m *mockUpdater) IsInsecureRegistry() bool {
+	args := m.Called()
+	return args.Get(0).(bool)
+}
+func (m *mockUpdater) TrustPolicy() dynatracev1beta1.TrustPolicy {
+	args := m.Called()
+	return args.Get(0).(dynatracev1beta1.TrustPolicy)
+}
+
+type mockSignatureVerifier struct {
+	mock.Mock
+}
+
+func (m *mockSignatureVerifier) Verify(ctx context.Context, imageRepo, digest string, trustPolicy dynatracev1beta1.TrustPolicy) error {
+	args := m.Called(ctx, imageRepo, digest, trustPolicy)
+	return args.Error(0)
+}
 
 func TestRun(t *testing.T) {
 	ctx := context.TODO()
@@ -147,6 +166,137 @@ func TestRun(t *testing.T) {
 		assertVersionStatusEquals(t, registry, getTaggedReference(t, testImage.String()), *target)
 		assert.Equal(t, target.ImageTag, target.Version)
 	})
+	t.Run("refuses an unsafe downgrade", func(t *testing.T) {
+		registry := newFakeRegistryForImages(testImage.String())
+		target := &dynatracev1beta1.VersionStatus{Version: "9.9.9"}
+		versionReconciler := Reconciler{
+			dynakube:     &dynatracev1beta1.DynaKube{},
+			timeProvider: timeProvider,
+			hashFunc:     registry.ImageVersionExt,
+		}
+		updater := newCustomImageUpdater(target, testImage.String())
+
+		err := versionReconciler.run(ctx, updater, testDockerCfg)
+
+		var upgradeErr InvalidUpgradeError
+		require.ErrorAs(t, err, &upgradeErr)
+		assert.Equal(t, "9.9.9", upgradeErr.Current)
+		assert.Equal(t, "1.2.3", upgradeErr.Target)
+		assert.Equal(t, "9.9.9", target.Version)
+		assert.Nil(t, target.LastProbeTimestamp)
+	})
+	t.Run("allows a downgrade when opted out via annotation", func(t *testing.T) {
+		registry := newFakeRegistryForImages(testImage.String())
+		target := &dynatracev1beta1.VersionStatus{Version: "9.9.9"}
+		versionReconciler := Reconciler{
+			dynakube:     allowDowngrade(&dynatracev1beta1.DynaKube{}),
+			timeProvider: timeProvider,
+			hashFunc:     registry.ImageVersionExt,
+		}
+		updater := newCustomImageUpdater(target, testImage.String())
+
+		err := versionReconciler.run(ctx, updater, testDockerCfg)
+
+		require.NoError(t, err)
+		assert.Equal(t, "1.2.3", target.Version)
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.TODO()
+	testImage := dtclient.LatestImageInfo{
+		Source: "some.registry.com",
+		Tag:    "1.2.3",
+	}
+	testDockerCfg := &dockerconfig.DockerConfig{}
+	timeProvider := timeprovider.New()
+
+	t.Run("a failing updater does not block the others", func(t *testing.T) {
+		brokenImage := dtclient.LatestImageInfo{Source: "broken.registry.com", Tag: "0.0.1"}
+		registry := newFakeRegistryForImages(testImage.String()) // brokenImage is deliberately NOT registered
+		versionReconciler := Reconciler{
+			dynakube:     &dynatracev1beta1.DynaKube{},
+			timeProvider: timeProvider,
+			hashFunc:     registry.ImageVersionExt,
+		}
+
+		workingTarget := &dynatracev1beta1.VersionStatus{}
+		workingUpdater := newCustomImageUpdater(workingTarget, testImage.String())
+
+		brokenTarget := &dynatracev1beta1.VersionStatus{}
+		brokenUpdater := newCustomImageUpdater(brokenTarget, brokenImage.String())
+
+		err := versionReconciler.Reconcile(ctx, testDockerCfg, workingUpdater, brokenUpdater)
+
+		require.Error(t, err)
+		assert.NotNil(t, workingTarget.LastProbeTimestamp)
+		assert.Nil(t, brokenTarget.LastProbeTimestamp)
+		assert.Equal(t, 1, brokenTarget.ConsecutiveFailures)
+		assert.Equal(t, timeProvider.Now(), brokenTarget.LastFailureTimestamp)
+	})
+
+	t.Run("a failing updater is skipped until its backoff elapses", func(t *testing.T) {
+		brokenImage := dtclient.LatestImageInfo{Source: "broken.registry.com", Tag: "0.0.1"}
+		registry := newEmptyFakeRegistry()
+		versionReconciler := Reconciler{
+			dynakube:     &dynatracev1beta1.DynaKube{},
+			timeProvider: timeProvider,
+			hashFunc:     registry.ImageVersionExt,
+		}
+
+		brokenTarget := &dynatracev1beta1.VersionStatus{}
+		brokenUpdater := newCustomImageUpdater(brokenTarget, brokenImage.String())
+
+		// 1st reconcile actually attempts the failing image and records the failure.
+		require.Error(t, versionReconciler.Reconcile(ctx, testDockerCfg, brokenUpdater))
+		brokenUpdater.AssertNumberOfCalls(t, "CustomImage", 1)
+		assert.Equal(t, 1, brokenTarget.ConsecutiveFailures)
+
+		// 2nd reconcile, immediately after, is within the backoff window and must not retry.
+		require.NoError(t, versionReconciler.Reconcile(ctx, testDockerCfg, brokenUpdater))
+		brokenUpdater.AssertNumberOfCalls(t, "CustomImage", 1)
+		assert.Equal(t, 1, brokenTarget.ConsecutiveFailures)
+	})
+}
+
+func TestReadyForRetry(t *testing.T) {
+	timeProvider := timeprovider.New()
+	versionReconciler := Reconciler{timeProvider: timeProvider}
+
+	t.Run("no previous failures => always ready", func(t *testing.T) {
+		target := &dynatracev1beta1.VersionStatus{}
+		assert.True(t, versionReconciler.readyForRetry(target))
+	})
+	t.Run("backoff not yet elapsed => not ready", func(t *testing.T) {
+		justFailed := metav1.NewTime(timeProvider.Now().Time)
+		target := &dynatracev1beta1.VersionStatus{ConsecutiveFailures: 3, LastFailureTimestamp: &justFailed}
+		assert.False(t, versionReconciler.readyForRetry(target))
+	})
+	t.Run("backoff elapsed => ready", func(t *testing.T) {
+		longAgo := metav1.NewTime(timeProvider.Now().Add(-1 * time.Hour))
+		target := &dynatracev1beta1.VersionStatus{ConsecutiveFailures: 3, LastFailureTimestamp: &longAgo}
+		assert.True(t, versionReconciler.readyForRetry(target))
+	})
+}
+
+func TestCheckDowngrade(t *testing.T) {
+	t.Run("rejects a lower semver target", func(t *testing.T) {
+		err := checkDowngrade(&dynatracev1beta1.DynaKube{}, "oneagent", "1.2.3", "1.2.0")
+		var upgradeErr InvalidUpgradeError
+		require.ErrorAs(t, err, &upgradeErr)
+	})
+	t.Run("allows a higher semver target", func(t *testing.T) {
+		err := checkDowngrade(&dynatracev1beta1.DynaKube{}, "oneagent", "1.2.3", "1.3.0")
+		require.NoError(t, err)
+	})
+	t.Run("skips non-semver versions, e.g. a digest", func(t *testing.T) {
+		err := checkDowngrade(&dynatracev1beta1.DynaKube{}, "oneagent", "sha256:aaaa", "sha256:bbbb")
+		require.NoError(t, err)
+	})
+	t.Run("skips when the feature annotation opts out", func(t *testing.T) {
+		err := checkDowngrade(allowDowngrade(&dynatracev1beta1.DynaKube{}), "oneagent", "1.2.3", "1.2.0")
+		require.NoError(t, err)
+	})
 }
 
 func TestDetermineSource(t *testing.T) {
@@ -184,17 +334,25 @@ func TestUpdateVersionStatus(t *testing.T) {
 	}
 	testDockerCfg := &dockerconfig.DockerConfig{}
 
+	noTrustPolicy := dynatracev1beta1.TrustPolicy{}
+	baseOpts := updateOptions{trustPolicy: noTrustPolicy}
+
+	withHashFunc := func(opts updateOptions, hashFunc hashFunction) updateOptions {
+		opts.hashFunc = hashFunc
+		return opts
+	}
+
 	t.Run("missing image", func(t *testing.T) {
 		registry := newEmptyFakeRegistry()
 		target := dynatracev1beta1.VersionStatus{}
-		err := updateVersionStatus(ctx, &target, testImage.String(), registry.ImageVersionExt, testDockerCfg)
+		err := updateVersionStatus(ctx, &target, testImage.String(), withHashFunc(baseOpts, registry.ImageVersionExt), testDockerCfg)
 		assert.Error(t, err)
 	})
 
 	t.Run("set status", func(t *testing.T) {
 		registry := newFakeRegistryForImages(testImage.String())
 		target := dynatracev1beta1.VersionStatus{}
-		err := updateVersionStatus(ctx, &target, testImage.String(), registry.ImageVersionExt, testDockerCfg)
+		err := updateVersionStatus(ctx, &target, testImage.String(), withHashFunc(baseOpts, registry.ImageVersionExt), testDockerCfg)
 		require.NoError(t, err)
 		assertVersionStatusEquals(t, registry, getTaggedReference(t, testImage.String()), target)
 	})
@@ -204,16 +362,130 @@ func TestUpdateVersionStatus(t *testing.T) {
 		expectedHash := "sha256:7ece13a07a20c77a31cc36906a10ebc90bd47970905ee61e8ed491b7f4c5d62f"
 		testImage := fmt.Sprintf(expectedRepo + "@" + expectedHash)
 		target := dynatracev1beta1.VersionStatus{}
-		boomFunc := func(_ context.Context, imagePath string, _ *dockerconfig.DockerConfig) (string, error) {
+		boomFunc := func(_ context.Context, imagePath string, _ bool, _ *dockerconfig.DockerConfig) (imageVersion, error) {
 			t.Error("hash function was called unexpectedly")
-			return "", nil
+			return imageVersion{}, nil
 		}
-		err := updateVersionStatus(ctx, &target, testImage, boomFunc, testDockerCfg)
+		err := updateVersionStatus(ctx, &target, testImage, withHashFunc(baseOpts, boomFunc), testDockerCfg)
 		require.NoError(t, err)
 		assert.Equal(t, expectedHash, target.ImageHash)
 		assert.Equal(t, expectedHash, target.ImageTag)
 		assert.Equal(t, expectedRepo, target.ImageRepository)
 	})
+
+	t.Run("a digest reference to a manifest list expands to per-arch entries", func(t *testing.T) {
+		expectedRepo := "some.registry.com/image"
+		expectedHash := "sha256:7ece13a07a20c77a31cc36906a10ebc90bd47970905ee61e8ed491b7f4c5d62f"
+		testImage := fmt.Sprintf(expectedRepo + "@" + expectedHash)
+		target := dynatracev1beta1.VersionStatus{}
+		archDigests := map[string]string{
+			"linux/amd64": "sha256:aaaa111111111111111111111111111111111111111111111111111111111a",
+			"linux/arm64": "sha256:bbbb222222222222222222222222222222222222222222222222222222222b",
+		}
+		opts := baseOpts
+		opts.archDigestFunc = func(_ context.Context, imageRef string, _ bool, _ *dockerconfig.DockerConfig) (map[string]string, error) {
+			assert.Equal(t, testImage, imageRef)
+			return archDigests, nil
+		}
+		opts.clusterPlatforms = []string{"linux/arm64"}
+
+		err := updateVersionStatus(ctx, &target, testImage, opts, testDockerCfg)
+
+		require.NoError(t, err)
+		assert.Equal(t, archDigests, target.ArchDigests)
+		assert.Equal(t, archDigests["linux/arm64"], target.ImageHash)
+		assert.Equal(t, expectedRepo, target.ImageRepository)
+	})
+
+	t.Run("a manifest list falls back to the index digest when the cluster's platforms are unknown", func(t *testing.T) {
+		expectedRepo := "some.registry.com/image"
+		expectedHash := "sha256:7ece13a07a20c77a31cc36906a10ebc90bd47970905ee61e8ed491b7f4c5d62f"
+		testImage := fmt.Sprintf(expectedRepo + "@" + expectedHash)
+		target := dynatracev1beta1.VersionStatus{}
+		archDigests := map[string]string{
+			"linux/amd64": "sha256:aaaa111111111111111111111111111111111111111111111111111111111a",
+		}
+		opts := baseOpts
+		opts.archDigestFunc = func(_ context.Context, _ string, _ bool, _ *dockerconfig.DockerConfig) (map[string]string, error) {
+			return archDigests, nil
+		}
+		// clusterPlatforms left empty, as clusterPlatforms() returns when the
+		// Reconciler has no apiReader or no node carries os/arch labels.
+
+		err := updateVersionStatus(ctx, &target, testImage, opts, testDockerCfg)
+
+		require.NoError(t, err)
+		assert.Equal(t, archDigests, target.ArchDigests)
+		assert.Equal(t, expectedHash, target.ImageHash)
+		assert.Equal(t, expectedRepo, target.ImageRepository)
+	})
+
+	t.Run("threads insecure flag to the hash function", func(t *testing.T) {
+		registry := newFakeRegistryForImages(testImage.String())
+		target := dynatracev1beta1.VersionStatus{}
+		var gotInsecure bool
+		spyFunc := func(ctx context.Context, imagePath string, insecure bool, dockerCfg *dockerconfig.DockerConfig) (imageVersion, error) {
+			gotInsecure = insecure
+			return registry.ImageVersionExt(ctx, imagePath, insecure, dockerCfg)
+		}
+		opts := withHashFunc(baseOpts, spyFunc)
+		opts.insecure = true
+		err := updateVersionStatus(ctx, &target, testImage.String(), opts, testDockerCfg)
+		require.NoError(t, err)
+		assert.True(t, gotInsecure)
+	})
+
+	t.Run("signed-ok: verified signature commits the status", func(t *testing.T) {
+		registry := newFakeRegistryForImages(testImage.String())
+		target := dynatracev1beta1.VersionStatus{}
+		trustPolicy := dynatracev1beta1.TrustPolicy{PublicKeys: []string{"-----BEGIN PUBLIC KEY-----"}}
+		verifier := &mockSignatureVerifier{}
+		verifier.On("Verify", mock.Anything, mock.Anything, mock.Anything, trustPolicy).Return(nil)
+		opts := withHashFunc(baseOpts, registry.ImageVersionExt)
+		opts.verifier = verifier
+		opts.trustPolicy = trustPolicy
+
+		err := updateVersionStatus(ctx, &target, testImage.String(), opts, testDockerCfg)
+
+		require.NoError(t, err)
+		assertVersionStatusEquals(t, registry, getTaggedReference(t, testImage.String()), target)
+	})
+
+	t.Run("signature-missing: rejected without writing the status", func(t *testing.T) {
+		registry := newFakeRegistryForImages(testImage.String())
+		target := dynatracev1beta1.VersionStatus{}
+		trustPolicy := dynatracev1beta1.TrustPolicy{PublicKeys: []string{"-----BEGIN PUBLIC KEY-----"}}
+		verifier := &mockSignatureVerifier{}
+		verifier.On("Verify", mock.Anything, mock.Anything, mock.Anything, trustPolicy).
+			Return(UntrustedImageError{Image: testImage.String(), Reason: "no signature found"})
+		opts := withHashFunc(baseOpts, registry.ImageVersionExt)
+		opts.verifier = verifier
+		opts.trustPolicy = trustPolicy
+
+		err := updateVersionStatus(ctx, &target, testImage.String(), opts, testDockerCfg)
+
+		var untrustedErr UntrustedImageError
+		require.ErrorAs(t, err, &untrustedErr)
+		assert.Empty(t, target.ImageHash)
+	})
+
+	t.Run("signature-mismatch: rejected without writing the status", func(t *testing.T) {
+		registry := newFakeRegistryForImages(testImage.String())
+		target := dynatracev1beta1.VersionStatus{}
+		trustPolicy := dynatracev1beta1.TrustPolicy{PublicKeys: []string{"-----BEGIN PUBLIC KEY-----"}}
+		verifier := &mockSignatureVerifier{}
+		verifier.On("Verify", mock.Anything, mock.Anything, mock.Anything, trustPolicy).
+			Return(UntrustedImageError{Image: testImage.String(), Reason: "signature does not match any trusted key"})
+		opts := withHashFunc(baseOpts, registry.ImageVersionExt)
+		opts.verifier = verifier
+		opts.trustPolicy = trustPolicy
+
+		err := updateVersionStatus(ctx, &target, testImage.String(), opts, testDockerCfg)
+
+		var untrustedErr UntrustedImageError
+		require.ErrorAs(t, err, &untrustedErr)
+		assert.Empty(t, target.ImageTag)
+	})
 }
 
 func enablePublicRegistry(dynakube *dynatracev1beta1.DynaKube) *dynatracev1beta1.DynaKube {
@@ -224,6 +496,14 @@ func enablePublicRegistry(dynakube *dynatracev1beta1.DynaKube) *dynatracev1beta1
 	return dynakube
 }
 
+func allowDowngrade(dynakube *dynatracev1beta1.DynaKube) *dynatracev1beta1.DynaKube {
+	if dynakube.Annotations == nil {
+		dynakube.Annotations = make(map[string]string)
+	}
+	dynakube.Annotations[dynatracev1beta1.AnnotationFeatureAllowDowngrade] = "true"
+	return dynakube
+}
+
 func newCustomImageUpdater(target *dynatracev1beta1.VersionStatus, image string) *mockUpdater {
 	updater := newBaseUpdater(target, true)
 	updater.On("CustomImage").Return(image)
@@ -261,6 +541,8 @@ func newBaseUpdater(target *dynatracev1beta1.VersionStatus, autoUpdate bool) *mo
 	updater.On("Target").Return(target)
 	updater.On("IsEnabled").Return(true)
 	updater.On("IsAutoUpdateEnabled").Return(autoUpdate)
+	updater.On("IsInsecureRegistry").Return(false)
+	updater.On("TrustPolicy").Return(dynatracev1beta1.TrustPolicy{})
 	return &updater
 }
 